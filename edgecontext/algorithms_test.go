@@ -0,0 +1,89 @@
+package edgecontext
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestParsePublicKeyPEM(t *testing.T) {
+	rsaKey := mustRSAKey(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating ECDSA key: %v", err)
+	}
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating Ed25519 key: %v", err)
+	}
+
+	cases := []struct {
+		name    string
+		key     interface{}
+		wantAlg string
+	}{
+		{"rsa", &rsaKey.PublicKey, "RS256"},
+		{"ecdsa", &ecKey.PublicKey, "ES256"},
+		{"ed25519", edPub, "EdDSA"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			der, err := x509.MarshalPKIXPublicKey(c.key)
+			if err != nil {
+				t.Fatalf("marshaling public key: %v", err)
+			}
+			raw := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+			key, alg, err := parsePublicKeyPEM(raw)
+			if err != nil {
+				t.Fatalf("parsePublicKeyPEM: %v", err)
+			}
+			if alg != c.wantAlg {
+				t.Errorf("got alg %q, want %q", alg, c.wantAlg)
+			}
+			if !reflect.DeepEqual(key, c.key) {
+				t.Errorf("got key %#v, want %#v", key, c.key)
+			}
+		})
+	}
+}
+
+// TestKeyStoreKeyFuncRejectsWrongAlgorithm guards against algorithm
+// confusion: a key loaded for one algorithm must never be handed back for a
+// token that declares a different one, even under the same kid.
+func TestKeyStoreKeyFuncRejectsWrongAlgorithm(t *testing.T) {
+	store := newKeyStore()
+	store.add("k1", &mustRSAKey(t).PublicKey, "RS256")
+
+	_, err := store.KeyFunc(&jwt.Token{
+		Header: map[string]interface{}{"kid": "k1"},
+		Method: jwt.SigningMethodES256,
+	})
+	if err == nil {
+		t.Fatal("expected the algorithm mismatch to be rejected")
+	}
+}
+
+func TestAllowedAlgorithmsDefaultAndOverride(t *testing.T) {
+	defer allowedAlgorithmsValue.Store(append([]string(nil), defaultAllowedAlgorithms...))
+
+	allowedAlgorithmsValue = atomic.Value{}
+	if got := allowedAlgorithms(); !reflect.DeepEqual(got, defaultAllowedAlgorithms) {
+		t.Errorf("default allowedAlgorithms() = %v, want %v", got, defaultAllowedAlgorithms)
+	}
+
+	SetAllowedAlgorithms("RS256", "EdDSA")
+	want := []string{"RS256", "EdDSA"}
+	if got := allowedAlgorithms(); !reflect.DeepEqual(got, want) {
+		t.Errorf("allowedAlgorithms() after SetAllowedAlgorithms = %v, want %v", got, want)
+	}
+}
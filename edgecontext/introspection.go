@@ -0,0 +1,245 @@
+package edgecontext
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/reddit/baseplate.go/secrets"
+)
+
+// introspectionCredentialSecretPath holds the client_id/client_secret used to
+// authenticate to the introspection endpoint, following the same
+// credential-via-secrets-store pattern as authenticationPubKeySecretPath.
+const introspectionCredentialSecretPath = "secret/authentication/introspection-client"
+
+// introspectionHTTPTimeout bounds a single call to the introspection
+// endpoint, so a hung IdP can't block a ValidateToken caller (typically
+// inline in request-handling middleware) forever.
+const introspectionHTTPTimeout = 5 * time.Second
+
+// cacheSweepInterval is how often stale, never-looked-up-again cache
+// entries are evicted, so a service that introspects many distinct opaque
+// tokens (e.g. one per user session) doesn't grow the cache unboundedly.
+const cacheSweepInterval = time.Minute
+
+var tokenIntrospectorValue atomic.Value // *TokenIntrospector
+
+// SetTokenIntrospector configures the RFC 7662 introspection endpoint used
+// to authenticate opaque bearer tokens: tokens that aren't signed JWTs, or
+// that fail local JWT validation. If this is never called, ValidateToken
+// only accepts locally-verifiable JWTs.
+func SetTokenIntrospector(ti *TokenIntrospector) {
+	if old, ok := tokenIntrospectorValue.Load().(*TokenIntrospector); ok && old != nil {
+		old.Close()
+	}
+	tokenIntrospectorValue.Store(ti)
+}
+
+// introspectionMiddleware loads the introspection client credentials from
+// the secrets store and installs a TokenIntrospector pointed at url,
+// mirroring how validatorMiddleware loads the JWT public key.
+func introspectionMiddleware(url string, ttl time.Duration, next secrets.SecretHandlerFunc) secrets.SecretHandlerFunc {
+	return func(sec *secrets.Secrets) {
+		defer next(sec)
+
+		cred, err := sec.GetCredentialSecret(introspectionCredentialSecretPath)
+		if err != nil {
+			logger(fmt.Sprintf(
+				"Failed to get secrets %q: %v",
+				introspectionCredentialSecretPath,
+				err,
+			))
+			return
+		}
+
+		SetTokenIntrospector(NewTokenIntrospector(url, cred.Username, cred.Password, ttl))
+	}
+}
+
+// introspectionResponse is the subset of the RFC 7662 token introspection
+// response ValidateToken needs to build an AuthenticationToken.
+type introspectionResponse struct {
+	Active bool   `json:"active"`
+	Sub    string `json:"sub"`
+	Scope  string `json:"scope"`
+	Exp    int64  `json:"exp"`
+}
+
+type introspectionCacheEntry struct {
+	resp      introspectionResponse
+	expiresAt time.Time
+}
+
+// introspectionCall lets concurrent Introspect calls for the same token
+// share a single outstanding request to the IdP.
+type introspectionCall struct {
+	done chan struct{}
+	resp introspectionResponse
+	err  error
+}
+
+// TokenIntrospector authenticates opaque OAuth2 access tokens against an RFC
+// 7662 introspection endpoint, for services fronted by an external IdP that
+// issues tokens baseplate can't verify locally.
+type TokenIntrospector struct {
+	url          string
+	clientID     string
+	clientSecret string
+	ttl          time.Duration
+	httpClient   *http.Client
+
+	mu     sync.Mutex
+	cache  map[string]introspectionCacheEntry
+	flight map[string]*introspectionCall
+
+	stopSweep chan struct{}
+}
+
+// NewTokenIntrospector creates a TokenIntrospector that calls introspectURL
+// with the given client credentials, caching each result for ttl. It starts
+// a background goroutine that periodically evicts expired cache entries;
+// call Close to stop it.
+func NewTokenIntrospector(introspectURL, clientID, clientSecret string, ttl time.Duration) *TokenIntrospector {
+	ti := &TokenIntrospector{
+		url:          introspectURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		ttl:          ttl,
+		httpClient:   &http.Client{Timeout: introspectionHTTPTimeout},
+		cache:        make(map[string]introspectionCacheEntry),
+		flight:       make(map[string]*introspectionCall),
+		stopSweep:    make(chan struct{}),
+	}
+	go ti.sweepLoop()
+	return ti
+}
+
+// Close stops the background cache-eviction goroutine.
+func (ti *TokenIntrospector) Close() {
+	close(ti.stopSweep)
+}
+
+func (ti *TokenIntrospector) sweepLoop() {
+	ticker := time.NewTicker(cacheSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ti.sweep()
+		case <-ti.stopSweep:
+			return
+		}
+	}
+}
+
+// sweep evicts every cache entry that's past its TTL, so tokens that are
+// only ever looked up once don't accumulate in the cache forever.
+func (ti *TokenIntrospector) sweep() {
+	now := time.Now()
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	for token, entry := range ti.cache {
+		if now.After(entry.expiresAt) {
+			delete(ti.cache, token)
+		}
+	}
+}
+
+// Introspect authenticates token against the configured introspection
+// endpoint and returns the decoded AuthenticationToken. Concurrent calls for
+// the same token share a single in-flight request, and active results are
+// cached for the introspector's TTL.
+func (ti *TokenIntrospector) Introspect(ctx context.Context, token string) (*AuthenticationToken, error) {
+	resp, err := ti.result(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return introspectionToAuthToken(resp)
+}
+
+func (ti *TokenIntrospector) result(ctx context.Context, token string) (introspectionResponse, error) {
+	ti.mu.Lock()
+	if entry, ok := ti.cache[token]; ok && time.Now().Before(entry.expiresAt) {
+		ti.mu.Unlock()
+		return entry.resp, nil
+	}
+	if call, ok := ti.flight[token]; ok {
+		ti.mu.Unlock()
+		<-call.done
+		return call.resp, call.err
+	}
+
+	call := &introspectionCall{done: make(chan struct{})}
+	ti.flight[token] = call
+	ti.mu.Unlock()
+
+	call.resp, call.err = ti.introspect(ctx, token)
+
+	ti.mu.Lock()
+	delete(ti.flight, token)
+	if call.err == nil {
+		ti.cache[token] = introspectionCacheEntry{
+			resp:      call.resp,
+			expiresAt: time.Now().Add(ti.ttl),
+		}
+	}
+	ti.mu.Unlock()
+
+	close(call.done)
+	return call.resp, call.err
+}
+
+func (ti *TokenIntrospector) introspect(ctx context.Context, token string) (introspectionResponse, error) {
+	form := url.Values{"token": {token}}
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, ti.url, strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return introspectionResponse{}, fmt.Errorf("edgecontext: building introspection request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(ti.clientID, ti.clientSecret)
+
+	resp, err := ti.httpClient.Do(req)
+	if err != nil {
+		return introspectionResponse{}, fmt.Errorf("edgecontext: calling introspection endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var parsed introspectionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return introspectionResponse{}, fmt.Errorf("edgecontext: decoding introspection response: %w", err)
+	}
+	if !parsed.Active {
+		return introspectionResponse{}, fmt.Errorf("edgecontext: token is not active")
+	}
+	return parsed, nil
+}
+
+// introspectionToAuthToken decodes an introspection response through the
+// same JSON claims shape AuthenticationToken already knows how to read off
+// a locally-verified JWT, so an introspected token produces an identically
+// shaped claims object.
+func introspectionToAuthToken(resp introspectionResponse) (*AuthenticationToken, error) {
+	raw, err := json.Marshal(map[string]interface{}{
+		"sub":   resp.Sub,
+		"scope": resp.Scope,
+		"exp":   resp.Exp,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("edgecontext: encoding introspected claims: %w", err)
+	}
+
+	claims := &AuthenticationToken{}
+	if err := json.Unmarshal(raw, claims); err != nil {
+		return nil, fmt.Errorf("edgecontext: decoding introspected claims: %w", err)
+	}
+	return claims, nil
+}
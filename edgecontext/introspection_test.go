@@ -0,0 +1,131 @@
+package edgecontext
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenIntrospectorCachesActiveResponse(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(introspectionResponse{
+			Active: true,
+			Sub:    "user-1",
+			Scope:  "a b",
+			Exp:    time.Now().Add(time.Hour).Unix(),
+		})
+	}))
+	defer server.Close()
+
+	ti := NewTokenIntrospector(server.URL, "client", "secret", time.Minute)
+	defer ti.Close()
+
+	const token = "opaque-token"
+	if _, err := ti.Introspect(context.Background(), token); err != nil {
+		t.Fatalf("first Introspect: %v", err)
+	}
+	if _, err := ti.Introspect(context.Background(), token); err != nil {
+		t.Fatalf("second Introspect: %v", err)
+	}
+
+	if got := atomic.LoadInt32(&hits); got != 1 {
+		t.Errorf("introspection endpoint was hit %d times, want 1 (second call should be cached)", got)
+	}
+}
+
+// TestTokenIntrospectorSingleflight makes sure concurrent Introspect calls
+// for the same token share one outstanding request instead of each fanning
+// out to the IdP.
+func TestTokenIntrospectorSingleflight(t *testing.T) {
+	var hits int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(introspectionResponse{
+			Active: true,
+			Sub:    "user-1",
+			Exp:    time.Now().Add(time.Hour).Unix(),
+		})
+	}))
+	defer server.Close()
+
+	ti := NewTokenIntrospector(server.URL, "client", "secret", time.Minute)
+	defer ti.Close()
+
+	const n = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ti.Introspect(context.Background(), "shared-token")
+			errs <- err
+		}()
+	}
+
+	// Give the goroutines a moment to pile up behind the single in-flight
+	// call before letting the handler respond.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Introspect returned error: %v", err)
+		}
+	}
+	if got := atomic.LoadInt32(&hits); got != 1 {
+		t.Errorf("introspection endpoint was hit %d times, want exactly 1", got)
+	}
+}
+
+func TestTokenIntrospectorNotActive(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(introspectionResponse{Active: false})
+	}))
+	defer server.Close()
+
+	ti := NewTokenIntrospector(server.URL, "client", "secret", time.Minute)
+	defer ti.Close()
+
+	if _, err := ti.Introspect(context.Background(), "bad-token"); err == nil {
+		t.Fatal("expected an error for an inactive token")
+	}
+}
+
+// TestTokenIntrospectorSweepEvictsExpired guards against the cache growing
+// unboundedly for services that introspect many distinct opaque tokens that
+// are each only ever looked up once.
+func TestTokenIntrospectorSweepEvictsExpired(t *testing.T) {
+	ti := NewTokenIntrospector("http://example.invalid", "client", "secret", time.Minute)
+	defer ti.Close()
+
+	ti.mu.Lock()
+	ti.cache["expired"] = introspectionCacheEntry{expiresAt: time.Now().Add(-time.Minute)}
+	ti.cache["fresh"] = introspectionCacheEntry{expiresAt: time.Now().Add(time.Minute)}
+	ti.mu.Unlock()
+
+	ti.sweep()
+
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	if _, ok := ti.cache["expired"]; ok {
+		t.Error("sweep did not evict the expired entry")
+	}
+	if _, ok := ti.cache["fresh"]; !ok {
+		t.Error("sweep evicted a still-valid entry")
+	}
+}
@@ -0,0 +1,312 @@
+package edgecontext
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// defaultAllowedAlgorithms is used until SetAllowedAlgorithms is called.
+var defaultAllowedAlgorithms = []string{"RS256"}
+
+var allowedAlgorithmsValue atomic.Value
+
+// SetAllowedAlgorithms configures which JWT signing algorithms
+// ValidateToken will accept; tokens signed with any other algorithm are
+// rejected outright. The default, if this is never called, is RS256 only.
+func SetAllowedAlgorithms(algs ...string) {
+	allowedAlgorithmsValue.Store(append([]string(nil), algs...))
+}
+
+func allowedAlgorithms() []string {
+	algs, ok := allowedAlgorithmsValue.Load().([]string)
+	if !ok {
+		return defaultAllowedAlgorithms
+	}
+	return algs
+}
+
+// parsePublicKeyPEM parses a PEM-encoded public key and returns it along
+// with the JWT algorithm it's meant to verify. It accepts legacy "RSA
+// PUBLIC KEY" (PKCS1) blocks as well as the "PUBLIC KEY" (PKIX) blocks
+// produced for RSA, ECDSA (ES256, via P-256), and Ed25519 (EdDSA) keys.
+func parsePublicKeyPEM(raw []byte) (key interface{}, alg string, err error) {
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(raw); err == nil {
+		return key, "RS256", nil
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, "", errors.New("edgecontext: no PEM block found")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, "", fmt.Errorf("edgecontext: parsing public key: %w", err)
+	}
+	switch pub := parsed.(type) {
+	case *rsa.PublicKey:
+		return pub, "RS256", nil
+	case *ecdsa.PublicKey:
+		return pub, "ES256", nil
+	case ed25519.PublicKey:
+		return pub, "EdDSA", nil
+	default:
+		return nil, "", fmt.Errorf("edgecontext: unsupported public key type %T", parsed)
+	}
+}
+
+// vaultKeysValue and jwksKeysValue hold the most recently loaded *keyStore
+// from each source; keysValue (declared alongside AuthenticationToken) holds
+// the merge of the two and is what ValidateToken actually reads.
+var (
+	vaultKeysValue atomic.Value
+	jwksKeysValue  atomic.Value
+)
+
+// mergeMu serializes mergeVaultKeys and mergeJWKSKeys against each other.
+// Each does a read-merge-store across both atomic.Values; without a shared
+// lock, a Vault rotation and a JWKS poll landing concurrently could each
+// read a stale snapshot of the other source and the slower one to finish
+// would overwrite keysValue with a merge that drops the other's just-loaded
+// key, until that source's next refresh.
+var mergeMu sync.Mutex
+
+// errNoKeyID is returned by keyStore.KeyFunc when the token being verified
+// has no "kid" header, signaling ValidateToken to fall back to trying every
+// known key instead of a direct lookup.
+var errNoKeyID = errors.New("edgecontext: token has no kid header")
+
+// storedKey pairs a parsed public key with the algorithm it's meant to
+// verify, so a key can never be used to check a token signed with a
+// different algorithm than the one it was loaded for.
+type storedKey struct {
+	key interface{} // *rsa.PublicKey, *ecdsa.PublicKey, or ed25519.PublicKey
+	alg string
+}
+
+// KeySource supplies the public key(s) used to verify inbound JWTs. It
+// implements jwt.Keyfunc directly so it can be passed straight to
+// jwt.ParseWithClaims, and it also exposes the keys for a given algorithm
+// for callers that need to fall back to trying every key, for tokens with
+// no "kid" header.
+type KeySource interface {
+	// KeyFunc implements jwt.Keyfunc, selecting the key matching the
+	// token's "kid" header. It returns errNoKeyID if the token has no
+	// "kid" header, and rejects the key if it was not loaded for the
+	// token's declared algorithm.
+	KeyFunc(token *jwt.Token) (interface{}, error)
+
+	// KeysForAlg returns every known public key loaded for the given
+	// algorithm.
+	KeysForAlg(alg string) []interface{}
+}
+
+// keyStore is the default KeySource, indexing public keys by kid and by
+// algorithm.
+type keyStore struct {
+	byKid map[string]storedKey
+	byAlg map[string][]interface{}
+}
+
+func newKeyStore() *keyStore {
+	return &keyStore{
+		byKid: make(map[string]storedKey),
+		byAlg: make(map[string][]interface{}),
+	}
+}
+
+func (ks *keyStore) add(kid string, key interface{}, alg string) {
+	if kid != "" {
+		ks.byKid[kid] = storedKey{key: key, alg: alg}
+	}
+	ks.byAlg[alg] = append(ks.byAlg[alg], key)
+}
+
+func (ks *keyStore) len() int {
+	var n int
+	for _, keys := range ks.byAlg {
+		n += len(keys)
+	}
+	return n
+}
+
+func (ks *keyStore) KeyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errNoKeyID
+	}
+	stored, ok := ks.byKid[kid]
+	if !ok {
+		return nil, fmt.Errorf("edgecontext: no key found for kid %q", kid)
+	}
+	if stored.alg != token.Method.Alg() {
+		return nil, fmt.Errorf(
+			"edgecontext: key for kid %q was loaded for %s, not %s",
+			kid, stored.alg, token.Method.Alg(),
+		)
+	}
+	return stored.key, nil
+}
+
+func (ks *keyStore) KeysForAlg(alg string) []interface{} {
+	return ks.byAlg[alg]
+}
+
+// mergeVaultKeys replaces the Vault-sourced keys in the active KeySource
+// while preserving any keys previously loaded from JWKS, and vice versa for
+// mergeJWKSKeys. Vault and JWKS are refreshed independently (Vault via
+// validatorMiddleware, JWKS via PollJWKS), so neither refresh should drop
+// the other's keys.
+func mergeVaultKeys(vault *keyStore) {
+	mergeMu.Lock()
+	defer mergeMu.Unlock()
+
+	vaultKeysValue.Store(vault)
+	keysValue.Store(mergeKeyStores(vault, jwksKeysValue.Load()))
+}
+
+func mergeJWKSKeys(jwks *keyStore) {
+	mergeMu.Lock()
+	defer mergeMu.Unlock()
+
+	jwksKeysValue.Store(jwks)
+	keysValue.Store(mergeKeyStores(vaultKeysValue.Load(), jwks))
+}
+
+func mergeKeyStores(vault, jwks interface{}) *keyStore {
+	merged := newKeyStore()
+	if store, ok := vault.(*keyStore); ok {
+		for kid, stored := range store.byKid {
+			merged.add(kid, stored.key, stored.alg)
+		}
+	}
+	if store, ok := jwks.(*keyStore); ok {
+		for kid, stored := range store.byKid {
+			merged.add(kid, stored.key, stored.alg)
+		}
+	}
+	return merged
+}
+
+// jwk is a single JSON Web Key as returned by a JWKS endpoint. Only the
+// fields needed to reconstruct an RSA public key are decoded.
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwksResponse struct {
+	Keys []jwk `json:"keys"`
+}
+
+func (k jwk) rsaPublicKey() (*rsa.PublicKey, error) {
+	if k.Kty != "RSA" {
+		return nil, fmt.Errorf("edgecontext: unsupported JWKS key type %q", k.Kty)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("edgecontext: decoding JWKS modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("edgecontext: decoding JWKS exponent: %w", err)
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+func fetchJWKS(url string) (*keyStore, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("edgecontext: fetching JWKS from %q: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("edgecontext: fetching JWKS from %q: unexpected status %q", url, resp.Status)
+	}
+
+	var parsed jwksResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("edgecontext: decoding JWKS from %q: %w", url, err)
+	}
+
+	store := newKeyStore()
+	for i, k := range parsed.Keys {
+		if k.Kid == "" {
+			// keyStore indexes keys by kid; a keyless entry would only ever
+			// be reachable through KeysForAlg, and mergeKeyStores only
+			// merges byKid, so it would otherwise be accepted here and then
+			// silently dropped on the next merge. Reject it up front instead.
+			logger(fmt.Sprintf("Skipping JWKS key #%d from %q: no kid", i, url))
+			continue
+		}
+		key, err := k.rsaPublicKey()
+		if err != nil {
+			logger(fmt.Sprintf("Failed to parse JWKS key #%d from %q: %v", i, url, err))
+			continue
+		}
+		// JWKS endpoints we poll today only ever serve RSA keys; EdDSA/ES256
+		// keys are loaded from Vault via validatorMiddleware instead.
+		store.add(k.Kid, key, "RS256")
+	}
+	return store, nil
+}
+
+// PollJWKS fetches the JWKS served at url on the given interval and merges
+// the keys it finds into the KeySource used by ValidateToken, in addition to
+// whatever is loaded from the Vault secret store. It is optional: services
+// that only issue tokens signed with the Vault-backed authentication key
+// don't need to call it. The returned stop func ends the polling goroutine.
+func PollJWKS(url string, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+
+	poll := func() {
+		store, err := fetchJWKS(url)
+		if err != nil {
+			logger(err.Error())
+			return
+		}
+		if store.len() == 0 {
+			// Same guard as validatorMiddleware: an empty response (IdP
+			// maintenance page, transient empty "keys" during its own
+			// rotation, etc.) must not wipe out keys we already trust.
+			logger(fmt.Sprintf("No valid keys in JWKS response from %q.", url))
+			return
+		}
+		mergeJWKSKeys(store)
+	}
+
+	poll()
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				poll()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,176 @@
+package edgecontext
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func mustRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key: %v", err)
+	}
+	return key
+}
+
+func TestKeyStoreKeyFuncByKid(t *testing.T) {
+	key := &mustRSAKey(t).PublicKey
+	store := newKeyStore()
+	store.add("k1", key, "RS256")
+
+	got, err := store.KeyFunc(&jwt.Token{
+		Header: map[string]interface{}{"kid": "k1"},
+		Method: jwt.SigningMethodRS256,
+	})
+	if err != nil {
+		t.Fatalf("KeyFunc returned error: %v", err)
+	}
+	if got != interface{}(key) {
+		t.Fatal("KeyFunc did not return the key loaded for this kid")
+	}
+}
+
+func TestKeyStoreKeyFuncNoKid(t *testing.T) {
+	store := newKeyStore()
+	_, err := store.KeyFunc(&jwt.Token{
+		Header: map[string]interface{}{},
+		Method: jwt.SigningMethodRS256,
+	})
+	if err != errNoKeyID {
+		t.Fatalf("got error %v, want errNoKeyID", err)
+	}
+}
+
+func TestKeyStoreKeyFuncUnknownKid(t *testing.T) {
+	store := newKeyStore()
+	_, err := store.KeyFunc(&jwt.Token{
+		Header: map[string]interface{}{"kid": "missing"},
+		Method: jwt.SigningMethodRS256,
+	})
+	if err == nil {
+		t.Fatal("expected an error for an unknown kid")
+	}
+}
+
+func TestMergeKeyStoresPreservesBothSources(t *testing.T) {
+	vault := newKeyStore()
+	vault.add("vault-1", &mustRSAKey(t).PublicKey, "RS256")
+	jwks := newKeyStore()
+	jwks.add("jwks-1", &mustRSAKey(t).PublicKey, "RS256")
+
+	merged := mergeKeyStores(vault, jwks)
+	if _, ok := merged.byKid["vault-1"]; !ok {
+		t.Error("merged store is missing the vault-sourced key")
+	}
+	if _, ok := merged.byKid["jwks-1"]; !ok {
+		t.Error("merged store is missing the JWKS-sourced key")
+	}
+}
+
+func rsaToJWK(kid string, pub *rsa.PublicKey) jwk {
+	return jwk{
+		Kid: kid,
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+// TestPollJWKSIgnoresEmptyResponse guards against a regression where an
+// empty (or otherwise keyless) JWKS response wiped out every previously
+// loaded JWKS key instead of being treated as a no-op refresh.
+func TestPollJWKSIgnoresEmptyResponse(t *testing.T) {
+	k := rsaToJWK("kid-1", &mustRSAKey(t).PublicKey)
+
+	var empty int32
+	requests := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if atomic.LoadInt32(&empty) != 0 {
+			json.NewEncoder(w).Encode(jwksResponse{})
+		} else {
+			json.NewEncoder(w).Encode(jwksResponse{Keys: []jwk{k}})
+		}
+		requests <- struct{}{}
+	}))
+	defer server.Close()
+
+	keysValue = atomic.Value{}
+	vaultKeysValue = atomic.Value{}
+	jwksKeysValue = atomic.Value{}
+
+	stop := PollJWKS(server.URL, 15*time.Millisecond)
+	defer stop()
+
+	select {
+	case <-requests:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the first JWKS poll")
+	}
+	if store, ok := jwksKeysValue.Load().(*keyStore); !ok || store.len() != 1 {
+		t.Fatalf("expected 1 key loaded after the first poll, got %#v", store)
+	}
+
+	atomic.StoreInt32(&empty, 1)
+	select {
+	case <-requests:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the second JWKS poll")
+	}
+	// Give mergeJWKSKeys a moment to run in case it's still in flight.
+	time.Sleep(20 * time.Millisecond)
+
+	store, ok := jwksKeysValue.Load().(*keyStore)
+	if !ok || store.len() != 1 {
+		t.Fatalf("an empty JWKS response wiped out previously loaded keys: %#v", store)
+	}
+}
+
+// TestFetchJWKSSkipsKeylessEntries guards against a regression where a JWKS
+// entry with no kid (optional per RFC 7517) was accepted by fetchJWKS but
+// then silently dropped by mergeKeyStores, which only merges byKid, making
+// tokens signed by that key unverifiable with no error or log line at the
+// point of loss.
+func TestFetchJWKSSkipsKeylessEntries(t *testing.T) {
+	keyed := rsaToJWK("kid-1", &mustRSAKey(t).PublicKey)
+	keyless := rsaToJWK("", &mustRSAKey(t).PublicKey)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jwksResponse{Keys: []jwk{keyed, keyless}})
+	}))
+	defer server.Close()
+
+	store, err := fetchJWKS(server.URL)
+	if err != nil {
+		t.Fatalf("fetchJWKS: %v", err)
+	}
+	if got := store.len(); got != 1 {
+		t.Fatalf("store.len() = %d, want 1 (keyless entry should be rejected, not silently merged away later)", got)
+	}
+	if _, ok := store.byKid["kid-1"]; !ok {
+		t.Error("expected the keyed entry to still be loaded")
+	}
+}
+
+func TestFetchJWKSNonOKStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if _, err := fetchJWKS(server.URL); err == nil {
+		t.Fatal("expected an error for a non-200 JWKS response")
+	}
+}
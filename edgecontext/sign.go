@@ -0,0 +1,219 @@
+package edgecontext
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/reddit/baseplate.go/secrets"
+)
+
+// authenticationPrivateKeySecretPath holds the private key baseplate
+// services sign outbound edge-context JWTs with, the mint-side counterpart
+// of authenticationPubKeySecretPath.
+const authenticationPrivateKeySecretPath = "secret/authentication/private-key"
+
+const defaultSignTTL = 5 * time.Minute
+
+// signingKey is the private key SignToken currently signs with, along with
+// the kid and algorithm it was loaded for.
+type signingKey struct {
+	key interface{} // *rsa.PrivateKey or ed25519.PrivateKey
+	kid string
+	alg string
+}
+
+var signingKeyValue atomic.Value // signingKey
+
+// signerMiddleware loads the private key used by SignToken, mirroring how
+// validatorMiddleware loads the public key ValidateToken verifies with.
+func signerMiddleware(next secrets.SecretHandlerFunc) secrets.SecretHandlerFunc {
+	return func(sec *secrets.Secrets) {
+		defer next(sec)
+
+		versioned, err := sec.GetVersionedSecret(authenticationPrivateKeySecretPath)
+		if err != nil {
+			logger(fmt.Sprintf(
+				"Failed to get secrets %q: %v",
+				authenticationPrivateKeySecretPath,
+				err,
+			))
+			return
+		}
+
+		all := versioned.GetAll()
+		if len(all) == 0 {
+			logger("No valid keys in secrets store.")
+			return
+		}
+
+		// Unlike the public keys ValidateToken verifies with, SignToken
+		// only ever signs with the newest version; older versions are
+		// kept around upstream purely so in-flight verifications against
+		// them don't break during rotation.
+		latest := len(all) - 1
+		key, alg, err := parsePrivateKeyPEM([]byte(all[latest]))
+		if err != nil {
+			logger(fmt.Sprintf("Failed to parse signing key: %v", err))
+			return
+		}
+
+		signingKeyValue.Store(signingKey{
+			key: key,
+			kid: fmt.Sprintf("%s#%d", authenticationPrivateKeySecretPath, latest),
+			alg: alg,
+		})
+	}
+}
+
+// parsePrivateKeyPEM parses a PEM-encoded private key and returns it along
+// with the JWT algorithm it should sign with. RSA (RS256) and Ed25519
+// (EdDSA) keys are supported; ECDSA (ES256) keys are rejected, even though
+// ValidateToken can be configured to accept ES256.
+func parsePrivateKeyPEM(raw []byte) (key interface{}, alg string, err error) {
+	if key, err := jwt.ParseRSAPrivateKeyFromPEM(raw); err == nil {
+		return key, "RS256", nil
+	}
+	if key, err := jwt.ParseEdPrivateKeyFromPEM(raw); err == nil {
+		return key, "EdDSA", nil
+	}
+	return nil, "", errors.New("edgecontext: unsupported or malformed private key PEM")
+}
+
+func signingMethodFor(alg string) (jwt.SigningMethod, error) {
+	switch alg {
+	case "RS256":
+		return jwt.SigningMethodRS256, nil
+	case "EdDSA":
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("edgecontext: unsupported signing algorithm %q", alg)
+	}
+}
+
+// signConfig holds the options a SignOption can set on a SignToken call.
+type signConfig struct {
+	issuer   string
+	audience string
+	ttl      time.Duration
+}
+
+// SignOption customizes a call to SignToken.
+type SignOption func(*signConfig)
+
+// WithIssuer sets the "iss" claim on the signed token.
+func WithIssuer(issuer string) SignOption {
+	return func(c *signConfig) { c.issuer = issuer }
+}
+
+// WithAudience sets the "aud" claim on the signed token.
+func WithAudience(audience string) SignOption {
+	return func(c *signConfig) { c.audience = audience }
+}
+
+// WithTTL sets how long the signed token is valid for. The default is
+// defaultSignTTL.
+func WithTTL(ttl time.Duration) SignOption {
+	return func(c *signConfig) { c.ttl = ttl }
+}
+
+// SignToken signs claims into a short-lived JWT that a baseplate service
+// can attach to outbound calls to non-baseplate systems (webhooks,
+// third-party call/image hosts, etc.), so the callee can authenticate the
+// caller through the same edge-context machinery ValidateToken uses for
+// inbound tokens. The token is signed with the private key loaded from
+// secret/authentication/private-key and tagged with a "kid" of the form
+// "secret/authentication/private-key#N" naming that secret's version. A
+// verifier using ValidateToken accepts it only if a public key was loaded
+// under that same kid; validatorMiddleware indexes Vault public keys as
+// "secret/authentication/public-key#N", so those keys do not match it.
+func SignToken(ctx context.Context, claims *AuthenticationToken, opts ...SignOption) (string, error) {
+	if claims == nil {
+		return "", errors.New("edgecontext: claims must not be nil")
+	}
+
+	signing, ok := signingKeyValue.Load().(signingKey)
+	if !ok {
+		return "", errors.New("edgecontext: no signing key loaded")
+	}
+
+	cfg := signConfig{ttl: defaultSignTTL}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	// Claims is marshaled through its own JSON representation rather than
+	// mutated field-by-field, so SignToken doesn't need to know which
+	// AuthenticationToken fields exist; iss/aud/exp/iat are then overlaid
+	// on top.
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("edgecontext: encoding claims: %w", err)
+	}
+	merged := jwt.MapClaims{}
+	if err := json.Unmarshal(raw, &merged); err != nil {
+		return "", fmt.Errorf("edgecontext: decoding claims: %w", err)
+	}
+
+	now := time.Now()
+	merged["iat"] = now.Unix()
+	merged["exp"] = now.Add(cfg.ttl).Unix()
+	if cfg.issuer != "" {
+		merged["iss"] = cfg.issuer
+	}
+	if cfg.audience != "" {
+		merged["aud"] = cfg.audience
+	}
+
+	method, err := signingMethodFor(signing.alg)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(method, merged)
+	token.Header["kid"] = signing.kid
+
+	signed, err := token.SignedString(signing.key)
+	if err != nil {
+		return "", fmt.Errorf("edgecontext: signing token: %w", err)
+	}
+	return signed, nil
+}
+
+// SignedRoundTripper wraps an http.RoundTripper and attaches a freshly
+// signed edge-context JWT to every outbound request as an
+// "Authorization: Bearer ..." header, for calling non-baseplate systems
+// that authenticate callers via edge-context tokens.
+type SignedRoundTripper struct {
+	// Base is the underlying RoundTripper. http.DefaultTransport is used
+	// if it's nil.
+	Base http.RoundTripper
+
+	// Claims is signed into the token attached to every request. Opts are
+	// passed through to SignToken unchanged.
+	Claims *AuthenticationToken
+	Opts   []SignOption
+}
+
+// RoundTrip implements http.RoundTripper.
+func (rt SignedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := SignToken(req.Context(), rt.Claims, rt.Opts...)
+	if err != nil {
+		return nil, fmt.Errorf("edgecontext: signing outbound token: %w", err)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	base := rt.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,140 @@
+package edgecontext
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// setSigningAndVerificationKeys points SignToken and validateJWT at a
+// matching key pair under kid, and allows alg for validateJWT for the
+// duration of the test, without needing a secrets.Secrets to drive
+// signerMiddleware/validatorMiddleware.
+func setSigningAndVerificationKeys(t *testing.T, kid, alg string, priv, pub interface{}) {
+	t.Helper()
+
+	prevAlgs := allowedAlgorithms()
+	SetAllowedAlgorithms(alg)
+	t.Cleanup(func() { SetAllowedAlgorithms(prevAlgs...) })
+
+	signingKeyValue = atomic.Value{}
+	signingKeyValue.Store(signingKey{key: priv, kid: kid, alg: alg})
+
+	store := newKeyStore()
+	store.add(kid, pub, alg)
+	keysValue = atomic.Value{}
+	keysValue.Store(store)
+}
+
+func TestSignTokenRoundTrip(t *testing.T) {
+	rsaPriv := mustRSAKey(t)
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating Ed25519 key: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		kid  string
+		alg  string
+		priv interface{}
+		pub  interface{}
+	}{
+		{"rs256", "kid-rsa", "RS256", rsaPriv, &rsaPriv.PublicKey},
+		{"eddsa", "kid-ed25519", "EdDSA", edPriv, edPub},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setSigningAndVerificationKeys(t, c.kid, c.alg, c.priv, c.pub)
+
+			token, err := SignToken(context.Background(), &AuthenticationToken{})
+			if err != nil {
+				t.Fatalf("SignToken: %v", err)
+			}
+
+			if _, err := validateJWT(token); err != nil {
+				t.Fatalf("validateJWT could not verify a token SignToken just produced: %v", err)
+			}
+		})
+	}
+}
+
+// TestSignTokenRejectsNilClaims guards against a panic: marshaling a nil
+// *AuthenticationToken produces the JSON literal "null", which unmarshals
+// into a pre-populated jwt.MapClaims and resets it to a nil map, so the
+// very next write to it would panic.
+func TestSignTokenRejectsNilClaims(t *testing.T) {
+	rsaPriv := mustRSAKey(t)
+	setSigningAndVerificationKeys(t, "kid-rsa", "RS256", rsaPriv, &rsaPriv.PublicKey)
+
+	if _, err := SignToken(context.Background(), nil); err == nil {
+		t.Fatal("expected an error for nil claims")
+	}
+}
+
+func TestSignTokenOptions(t *testing.T) {
+	rsaPriv := mustRSAKey(t)
+	setSigningAndVerificationKeys(t, "kid-rsa", "RS256", rsaPriv, &rsaPriv.PublicKey)
+
+	token, err := SignToken(
+		context.Background(), &AuthenticationToken{},
+		WithIssuer("test-issuer"), WithAudience("test-audience"), WithTTL(time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+
+	claims := jwt.MapClaims{}
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, claims)
+	if err != nil {
+		t.Fatalf("parsing signed token: %v", err)
+	}
+	if parsed.Header["kid"] != "kid-rsa" {
+		t.Errorf("kid header = %v, want %q", parsed.Header["kid"], "kid-rsa")
+	}
+	if claims["iss"] != "test-issuer" {
+		t.Errorf("iss claim = %v, want %q", claims["iss"], "test-issuer")
+	}
+	if claims["aud"] != "test-audience" {
+		t.Errorf("aud claim = %v, want %q", claims["aud"], "test-audience")
+	}
+	if _, ok := claims["exp"]; !ok {
+		t.Error("expected an exp claim to be set")
+	}
+}
+
+func TestSignedRoundTripperSetsAuthorizationHeader(t *testing.T) {
+	rsaPriv := mustRSAKey(t)
+	setSigningAndVerificationKeys(t, "kid-rsa", "RS256", rsaPriv, &rsaPriv.PublicKey)
+
+	var gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: SignedRoundTripper{Claims: &AuthenticationToken{}}}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("client.Get: %v", err)
+	}
+	resp.Body.Close()
+
+	if !strings.HasPrefix(gotAuth, "Bearer ") {
+		t.Errorf("Authorization header = %q, want a Bearer token", gotAuth)
+	}
+
+	token := strings.TrimPrefix(gotAuth, "Bearer ")
+	if _, err := validateJWT(token); err != nil {
+		t.Errorf("SignedRoundTripper attached a token that doesn't validate: %v", err)
+	}
+}
@@ -1,70 +1,120 @@
 package edgecontext
 
 import (
-	"crypto/rsa"
+	"context"
 	"errors"
 	"fmt"
+	"strings"
 
-	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+	"github.com/golang-jwt/jwt/v5"
 
 	"github.com/reddit/baseplate.go/secrets"
 )
 
-type keysType []*rsa.PublicKey
+const authenticationPubKeySecretPath = "secret/authentication/public-key"
 
-const (
-	authenticationPubKeySecretPath = "secret/authentication/public-key"
-	jwtAlg                         = "RS256"
-)
+// errNotAJWS is what ValidateToken returns, when no TokenIntrospector is
+// configured, for a token that isn't shaped like a JWS compact
+// serialization and so was not even tried as a local JWT.
+var errNotAJWS = errors.New("edgecontext: token is not a JWS")
+
+// looksLikeJWS reports whether token has the three dot-separated segments of
+// a JWS compact serialization. It's a cheap pre-check, not a validation.
+func looksLikeJWS(token string) bool {
+	return strings.Count(token, ".") == 2
+}
 
-// When trying versioned secret with jwt, there are some errors that won't be
-// fixed by the next version of the secret, so we can return early instead of
-// trying all the remaining versions.
-//
-// TODO: We can also get rid of this block when upstream added native support
-// for key rotation.
-var shortCircuitErrors = []uint32{
-	jwt.ValidationErrorMalformed,
-	jwt.ValidationErrorAudience,
-	jwt.ValidationErrorExpired,
-	jwt.ValidationErrorIssuedAt,
-	jwt.ValidationErrorIssuer,
-	jwt.ValidationErrorNotValidYet,
-	jwt.ValidationErrorId,
-	jwt.ValidationErrorClaimsInvalid,
+// shortCircuitErrors are the jwt/v5 sentinel errors that indicate a token
+// will never validate against any key, so ValidateToken can return
+// immediately instead of trying the remaining keys.
+var shortCircuitErrors = []error{
+	jwt.ErrTokenMalformed,
+	jwt.ErrTokenExpired,
+	jwt.ErrTokenNotValidYet,
+	jwt.ErrTokenInvalidAudience,
+	jwt.ErrTokenInvalidIssuer,
+	jwt.ErrTokenInvalidId,
+	jwt.ErrTokenInvalidClaims,
 }
 
 func shouldShortCircutError(err error) bool {
-	var ve jwt.ValidationError
-	if errors.As(err, &ve) {
-		for _, bitmask := range shortCircuitErrors {
-			if ve.Errors&bitmask != 0 {
-				return true
-			}
+	for _, sentinel := range shortCircuitErrors {
+		if errors.Is(err, sentinel) {
+			return true
 		}
 	}
 	return false
 }
 
-// ValidateToken parses and validates a jwt token, and return the decoded
-// AuthenticationToken.
+// ValidateToken authenticates a bearer token and returns the decoded
+// AuthenticationToken. It first tries to verify token as a locally-signed
+// JWT; if token isn't a JWS, or local verification fails, and a
+// TokenIntrospector has been configured via SetTokenIntrospector, it falls
+// through to RFC 7662 introspection instead, so services fronted by an
+// external IdP can accept both kinds of bearer token through the same path.
 func ValidateToken(token string) (*AuthenticationToken, error) {
-	keys, ok := keysValue.Load().(keysType)
+	var localErr error
+	if looksLikeJWS(token) {
+		claims, err := validateJWT(token)
+		if err == nil {
+			return claims, nil
+		}
+		localErr = err
+	} else {
+		localErr = errNotAJWS
+	}
+
+	ti, ok := tokenIntrospectorValue.Load().(*TokenIntrospector)
+	if !ok || ti == nil {
+		return nil, localErr
+	}
+	return ti.Introspect(context.Background(), token)
+}
+
+// validateJWT parses and validates token as a locally-signed jwt, and
+// returns the decoded AuthenticationToken.
+func validateJWT(token string) (*AuthenticationToken, error) {
+	source, ok := keysValue.Load().(KeySource)
 	if !ok {
 		// This would only happen when all previous middleware parsing failed.
 		return nil, errors.New("no public keys loaded")
 	}
 
-	// TODO: Patch upstream to support key rotation natively:
-	// https://github.com/dgrijalva/jwt-go/pull/372
-	var lastErr error
-	for _, key := range keys {
-		token, err := jwt.ParseWithClaims(
+	claims := &AuthenticationToken{}
+	parsed, err := jwt.ParseWithClaims(
+		token, claims, source.KeyFunc,
+		jwt.WithValidMethods(allowedAlgorithms()),
+	)
+	if err == nil {
+		if parsed.Valid {
+			return claims, nil
+		}
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	if !errors.Is(err, errNoKeyID) {
+		return nil, err
+	}
+
+	// The token has no "kid" header, so KeySource can't pick a single key
+	// for us: fall back to trying every key loaded for the token's declared
+	// algorithm, same as before native key-rotation support existed
+	// upstream.
+	unverified, _, peekErr := jwt.NewParser().ParseUnverified(token, &AuthenticationToken{})
+	if peekErr != nil {
+		return nil, peekErr
+	}
+
+	var lastErr error = err
+	for _, key := range source.KeysForAlg(unverified.Method.Alg()) {
+		key := key
+		claims := &AuthenticationToken{}
+		parsed, err := jwt.ParseWithClaims(
 			token,
-			&AuthenticationToken{},
+			claims,
 			func(_ *jwt.Token) (interface{}, error) {
 				return key, nil
 			},
+			jwt.WithValidMethods(allowedAlgorithms()),
 		)
 		if err != nil {
 			if shouldShortCircutError(err) {
@@ -75,11 +125,10 @@ func ValidateToken(token string) (*AuthenticationToken, error) {
 			continue
 		}
 
-		if claims, ok := token.Claims.(*AuthenticationToken); ok && token.Valid && token.Method.Alg() == jwtAlg {
+		if parsed.Valid {
 			return claims, nil
 		}
-
-		lastErr = jwt.NewValidationError("", 0)
+		lastErr = jwt.ErrTokenInvalidClaims
 	}
 	return nil, lastErr
 }
@@ -99,25 +148,28 @@ func validatorMiddleware(next secrets.SecretHandlerFunc) secrets.SecretHandlerFu
 		}
 
 		all := versioned.GetAll()
-		keys := make(keysType, 0, len(all))
+		store := newKeyStore()
 		for i, v := range all {
-			key, err := jwt.ParseRSAPublicKeyFromPEM([]byte(v))
+			key, alg, err := parsePublicKeyPEM([]byte(v))
 			if err != nil {
 				logger(fmt.Sprintf(
 					"Failed to parse key #%d: %v",
 					i,
 					err,
 				))
-			} else {
-				keys = append(keys, key)
+				continue
 			}
+			// Versioned secrets don't carry a kid of their own, so index
+			// them positionally; tokens signed with a kid are expected to
+			// match a key loaded via JWKS instead. See keysource.go.
+			store.add(fmt.Sprintf("%s#%d", authenticationPubKeySecretPath, i), key, alg)
 		}
 
-		if len(keys) == 0 {
+		if store.len() == 0 {
 			logger("No valid keys in secrets store.")
 			return
 		}
 
-		keysValue.Store(keys)
+		mergeVaultKeys(store)
 	}
 }